@@ -0,0 +1,647 @@
+package command
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/urfave/cli/v2"
+
+	errorpkg "github.com/peak/s5cmd/error"
+	"github.com/peak/s5cmd/log"
+	"github.com/peak/s5cmd/log/stat"
+	"github.com/peak/s5cmd/parallel"
+	"github.com/peak/s5cmd/storage"
+	"github.com/peak/s5cmd/storage/hash"
+	"github.com/peak/s5cmd/storage/url"
+)
+
+var bisyncHelpTemplate = `Name:
+	{{.HelpName}} - {{.Usage}}
+
+Usage:
+	{{.HelpName}} [options] pathA pathB
+
+Options:
+	{{range .VisibleFlags}}{{.}}
+	{{end}}
+`
+
+// changeKind classifies how a key moved since the last successful bisync
+// run, relative to the state recorded in the manifest.
+type changeKind int
+
+const (
+	unchanged changeKind = iota
+	changedOnA
+	changedOnB
+	newOnA
+	newOnB
+	deletedOnA
+	deletedOnB
+	conflicted
+)
+
+func NewBisyncCommandFlags() []cli.Flag {
+	flags := NewSyncCommandFlags()
+	return append(flags,
+		&cli.StringFlag{
+			Name:  "conflict-resolve",
+			Usage: "how to resolve a key changed on both sides since the last run: newer, larger, path1, or path2",
+		},
+		&cli.BoolFlag{
+			Name:  "resync",
+			Usage: "seed the manifest from the current state of the first path, treating it as authoritative",
+		},
+		&cli.Float64Flag{
+			Name:  "max-delete",
+			Value: 50,
+			Usage: "abort if propagated deletions would exceed this percentage of the manifest",
+		},
+	)
+}
+
+func NewBisyncCommand() *cli.Command {
+	return &cli.Command{
+		Name:               "bisync",
+		HelpName:           "bisync",
+		Usage:              "bidirectionally reconcile two prefixes",
+		Flags:              NewBisyncCommandFlags(),
+		CustomHelpTemplate: bisyncHelpTemplate,
+		Before: func(c *cli.Context) error {
+			err := validateBisyncCommand(c)
+			if err != nil {
+				printError(givenCommand(c), c.Command.Name, err)
+			}
+			return err
+		},
+		Action: func(c *cli.Context) (err error) {
+			defer stat.Collect(c.Command.FullName(), &err)()
+
+			return NewBisync(c).Run(c.Context)
+		},
+	}
+}
+
+// Bisync holds bisync operation flags and states. It reuses Sync's
+// transfer primitives (doUpload/doDownload/doCopy) in both directions:
+// unlike Sync, which only ever moves objects from a source to a
+// destination, bisync needs the same primitives available either way.
+type Bisync struct {
+	pathA       string
+	pathB       string
+	op          string
+	fullCommand string
+
+	conflictResolve string
+	resync          bool
+	maxDeletePct    float64
+
+	// s3 options, shared with the underlying transfer primitives.
+	concurrency       int
+	partSize          int64
+	storageOpts       storage.Options
+	checksum          bool
+	checksumAlgorithm hash.Algorithm
+	cacheDir          string
+	noCache           bool
+}
+
+// NewBisync creates Bisync from cli.Context.
+func NewBisync(c *cli.Context) Bisync {
+	return Bisync{
+		pathA:       c.Args().Get(0),
+		pathB:       c.Args().Get(1),
+		op:          c.Command.Name,
+		fullCommand: givenCommand(c),
+
+		conflictResolve: c.String("conflict-resolve"),
+		resync:          c.Bool("resync"),
+		maxDeletePct:    c.Float64("max-delete"),
+
+		partSize:          c.Int64("part-size") * megabytes,
+		concurrency:       c.Int("concurrency"),
+		storageOpts:       NewStorageOpts(c),
+		checksum:          c.Bool("checksum"),
+		checksumAlgorithm: hash.Algorithm(c.String("checksum-algorithm")),
+		cacheDir:          c.String("cache-dir"),
+		noCache:           c.Bool("no-cache"),
+	}
+}
+
+// transferHelper is a Sync value used purely to reach its unexported
+// doUpload/doDownload/doCopy primitives; its src/dst fields are unused by
+// those methods, which dispatch on the URLs they're handed directly.
+// cache is the transfer cache opened once by Run and shared across every
+// concurrently-dispatched propagateTask, the same way Sync.Run shares one
+// across its own concurrent doUpload calls.
+func (b Bisync) transferHelper(cache *transferCache) Sync {
+	return Sync{
+		op:                b.op,
+		fullCommand:       b.fullCommand,
+		concurrency:       b.concurrency,
+		partSize:          b.partSize,
+		storageOpts:       b.storageOpts,
+		checksum:          b.checksum,
+		checksumAlgorithm: b.checksumAlgorithm,
+		cacheDir:          b.cacheDir,
+		noCache:           b.noCache,
+		cache:             cache,
+	}
+}
+
+// bisyncEntry is a single manifest record: the state an object was in at
+// the end of the last successful bisync run.
+type bisyncEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time_unix_nano"`
+	ETag    string `json:"etag"`
+}
+
+// bisyncManifest is the persisted per-pair state file, keyed by the
+// object's relative key (its path under each side's prefix).
+type bisyncManifest map[string]bisyncEntry
+
+func entryFromObject(obj *storage.Object) bisyncEntry {
+	entry := bisyncEntry{Size: obj.Size, ETag: obj.Etag}
+	if obj.ModTime != nil {
+		entry.ModTime = obj.ModTime.UnixNano()
+	}
+	return entry
+}
+
+// changedSince reports whether obj's current state differs from prev, the
+// last state the manifest recorded for it.
+func changedSince(obj *storage.Object, prev bisyncEntry) bool {
+	if obj.Etag != "" && prev.ETag != "" {
+		return obj.Etag != prev.ETag
+	}
+	if obj.Size != prev.Size {
+		return true
+	}
+	return obj.ModTime != nil && obj.ModTime.UnixNano() != prev.ModTime
+}
+
+// manifestPath returns the path of the state file for the pair (pathA,
+// pathB), under ~/.s5cmd/bisync/, keyed by the sha256 of pathA and pathB
+// joined by a NUL byte, so the same ordered pair always resolves to the
+// same file; passing the two paths in the other order (or with a
+// different trailing slash) resolves to a different file.
+func manifestPath(pathA, pathB string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(pathA + "\x00" + pathB))
+	return filepath.Join(home, ".s5cmd", "bisync", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func loadManifest(path string) (bisyncManifest, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return bisyncManifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := bisyncManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func saveManifest(path string, manifest bisyncManifest) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// conflictMarkerPath returns where bisync records that key is in
+// conflict, so that a run that can't resolve it doesn't silently drop
+// the information on the floor. It lives next to the manifest rather
+// than inside either tree, since neither side necessarily accepts
+// arbitrary marker objects/files.
+func conflictMarkerPath(manifestFile, key string) string {
+	dir := strings.TrimSuffix(manifestFile, ".json") + ".conflicts"
+	return filepath.Join(dir, strings.ReplaceAll(key, "/", "_")+".conflict")
+}
+
+// Run performs one bisync reconciliation pass between pathA and pathB.
+func (b Bisync) Run(ctx context.Context) error {
+	urlA, err := url.New(b.pathA)
+	if err != nil {
+		printError(b.fullCommand, b.op, err)
+		return err
+	}
+
+	urlB, err := url.New(b.pathB)
+	if err != nil {
+		printError(b.fullCommand, b.op, err)
+		return err
+	}
+
+	clientA, err := storage.NewClient(ctx, urlA, b.storageOpts)
+	if err != nil {
+		printError(b.fullCommand, b.op, err)
+		return err
+	}
+
+	clientB, err := storage.NewClient(ctx, urlB, b.storageOpts)
+	if err != nil {
+		printError(b.fullCommand, b.op, err)
+		return err
+	}
+
+	manifestFile, err := manifestPath(b.pathA, b.pathB)
+	if err != nil {
+		printError(b.fullCommand, b.op, err)
+		return err
+	}
+
+	manifest, err := loadManifest(manifestFile)
+	if err != nil {
+		printError(b.fullCommand, b.op, err)
+		return err
+	}
+
+	if len(manifest) == 0 && !b.resync {
+		err := fmt.Errorf("no previous bisync state for %q <-> %q; run with --resync to seed it", b.pathA, b.pathB)
+		printError(b.fullCommand, b.op, err)
+		return err
+	}
+
+	chA := clientA.List(ctx, asPrefixURL(urlA), false)
+	chB := clientB.List(ctx, asPrefixURL(urlB), false)
+
+	changes, merror := b.diff(chA, chB, manifest)
+
+	if deletions, total := countDeletions(changes), len(manifest); total > 0 {
+		if pct := float64(deletions) / float64(total) * 100; pct > b.maxDeletePct {
+			err := fmt.Errorf("refusing to propagate %d deletions (%.1f%% of %d tracked keys), exceeds --max-delete %.1f%%",
+				deletions, pct, total, b.maxDeletePct)
+			printError(b.fullCommand, b.op, err)
+			return err
+		}
+	}
+
+	waiter := parallel.NewWaiter()
+	var merrorWaiter error
+	errDoneCh := make(chan bool)
+	go func() {
+		defer close(errDoneCh)
+		for err := range waiter.Err() {
+			printError(b.fullCommand, b.op, err)
+			merrorWaiter = multierror.Append(merrorWaiter, err)
+		}
+	}()
+
+	// Opened once and shared for the whole run, for the same reason
+	// Sync.Run shares one across its concurrent doUpload calls: each
+	// propagateTask independently opening and saving its own copy would
+	// race and drop entries.
+	var cache *transferCache
+	if !b.noCache {
+		var err error
+		cache, err = b.transferHelper(nil).openCache()
+		if err != nil {
+			printError(b.fullCommand, b.op, err)
+			cache = nil
+		}
+	}
+
+	helper := b.transferHelper(cache)
+	newManifest := bisyncManifest{}
+	// propagateTask runs as a task dispatched via parallel.Run (directly
+	// below, or from resolveConflict), so writes to newManifest happen from multiple
+	// goroutines concurrently; newManifestMu guards every write to it,
+	// including the unchanged case below, which runs on this goroutine
+	// while those tasks are still in flight.
+	var newManifestMu sync.Mutex
+
+	for _, ch := range changes {
+		switch ch.kind {
+		case unchanged:
+			newManifestMu.Lock()
+			newManifest[ch.key] = entryFromObject(ch.objA)
+			newManifestMu.Unlock()
+		case changedOnA, newOnA:
+			parallel.Run(b.propagateTask(ctx, helper, ch.objA, urlB, ch.key, newManifest, &newManifestMu), waiter)
+		case changedOnB, newOnB:
+			parallel.Run(b.propagateTask(ctx, helper, ch.objB, urlA, ch.key, newManifest, &newManifestMu), waiter)
+		case deletedOnA:
+			parallel.Run(b.deleteTask(ctx, clientB.Delete, ch.objB.URL), waiter)
+		case deletedOnB:
+			parallel.Run(b.deleteTask(ctx, clientA.Delete, ch.objA.URL), waiter)
+		case conflicted:
+			if err := b.resolveConflict(ctx, helper, ch, manifestFile, urlA, urlB, newManifest, &newManifestMu, waiter); err != nil {
+				merror = multierror.Append(merror, err)
+			}
+		}
+	}
+
+	waiter.Wait()
+	<-errDoneCh
+
+	if cache != nil {
+		if err := cache.save(); err != nil {
+			printError(b.fullCommand, b.op, err)
+			merrorWaiter = multierror.Append(merrorWaiter, err)
+		}
+	}
+
+	if err := saveManifest(manifestFile, newManifest); err != nil {
+		merror = multierror.Append(merror, err)
+	}
+
+	return multierror.Append(merror, merrorWaiter).ErrorOrNil()
+}
+
+type bisyncChange struct {
+	key        string
+	kind       changeKind
+	objA, objB *storage.Object
+}
+
+// diff walks both listings in lockstep, the same sorted merge-walk sync
+// uses, classifying every key against the manifest from the last
+// successful run.
+func (b Bisync) diff(
+	chA, chB <-chan *storage.Object,
+	manifest bisyncManifest,
+) ([]bisyncChange, error) {
+	var changes []bisyncChange
+	var merror error
+
+	objA, okA := <-chA
+	objB, okB := <-chB
+
+	for okA || okB {
+		switch {
+		case okB && (!okA || objB.URL.ObjectPath() < objA.URL.ObjectPath()):
+			if obj, ok := skipErrObj(objB, &merror); ok {
+				changes = append(changes, b.classifyOneSided(obj.URL.ObjectPath(), nil, obj, manifest))
+			}
+			objB, okB = <-chB
+		case okA && (!okB || objA.URL.ObjectPath() < objB.URL.ObjectPath()):
+			if obj, ok := skipErrObj(objA, &merror); ok {
+				changes = append(changes, b.classifyOneSided(obj.URL.ObjectPath(), obj, nil, manifest))
+			}
+			objA, okA = <-chA
+		default:
+			a, oka := skipErrObj(objA, &merror)
+			bb, okb := skipErrObj(objB, &merror)
+			if oka && okb {
+				changes = append(changes, b.classifyCommon(a.URL.ObjectPath(), a, bb, manifest))
+			}
+			objA, okA = <-chA
+			objB, okB = <-chB
+		}
+	}
+
+	return changes, merror
+}
+
+func skipErrObj(obj *storage.Object, merror *error) (*storage.Object, bool) {
+	if obj.Type.IsDir() || errorpkg.IsCancelation(obj.Err) {
+		return nil, false
+	}
+	if obj.Err != nil {
+		*merror = multierror.Append(*merror, obj.Err)
+		return nil, false
+	}
+	return obj, true
+}
+
+// asPrefixURL appends the wildcard sync's own listing needs to walk
+// everything under base.
+func asPrefixURL(base *url.URL) *url.URL {
+	path := base.Absolute()
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	u, _ := url.New(path + "*")
+	return u
+}
+
+func (b Bisync) classifyOneSided(key string, objA, objB *storage.Object, manifest bisyncManifest) bisyncChange {
+	if objA != nil { // only on A
+		if _, hadPrev := manifest[key]; hadPrev {
+			return bisyncChange{key: key, kind: deletedOnB, objA: objA}
+		}
+		return bisyncChange{key: key, kind: newOnA, objA: objA}
+	}
+
+	// only on B
+	if _, hadPrev := manifest[key]; hadPrev {
+		return bisyncChange{key: key, kind: deletedOnA, objB: objB}
+	}
+	return bisyncChange{key: key, kind: newOnB, objB: objB}
+}
+
+func (b Bisync) classifyCommon(key string, objA, objB *storage.Object, manifest bisyncManifest) bisyncChange {
+	prev, hadPrev := manifest[key]
+
+	if b.resync {
+		// Side A is authoritative: a key that already matches on both
+		// sides is recorded as unchanged without a transfer, and a key
+		// whose sides differ is classified as changed on A, so A's copy is
+		// propagated over B and recorded. Recording "unchanged" for a
+		// mismatch would bake a manifest entry that doesn't match B's real
+		// content, and the very next normal run would read that as B
+		// having changed and silently propagate it over A.
+		if changedSince(objB, entryFromObject(objA)) {
+			return bisyncChange{key: key, kind: changedOnA, objA: objA, objB: objB}
+		}
+		return bisyncChange{key: key, kind: unchanged, objA: objA, objB: objB}
+	}
+
+	// A key that is new on both sides since the last run needs no
+	// transfer if both sides already hold the same content.
+	if !hadPrev && !changedSince(objB, entryFromObject(objA)) {
+		return bisyncChange{key: key, kind: unchanged, objA: objA, objB: objB}
+	}
+
+	changedA := !hadPrev || changedSince(objA, prev)
+	changedB := !hadPrev || changedSince(objB, prev)
+
+	switch {
+	case !changedA && !changedB:
+		return bisyncChange{key: key, kind: unchanged, objA: objA, objB: objB}
+	case changedA && !changedB:
+		return bisyncChange{key: key, kind: changedOnA, objA: objA, objB: objB}
+	case changedB && !changedA:
+		return bisyncChange{key: key, kind: changedOnB, objA: objA, objB: objB}
+	default:
+		return bisyncChange{key: key, kind: conflicted, objA: objA, objB: objB}
+	}
+}
+
+func countDeletions(changes []bisyncChange) int {
+	n := 0
+	for _, ch := range changes {
+		if ch.kind == deletedOnA || ch.kind == deletedOnB {
+			n++
+		}
+	}
+	return n
+}
+
+// propagateTask copies src (on one side) to the equivalent key under
+// dstBase (on the other side), then records src's state in newManifest.
+// newManifestMu guards newManifest, since propagateTask is dispatched via
+// parallel.Run and many copies of it can be running concurrently.
+func (b Bisync) propagateTask(
+	ctx context.Context,
+	helper Sync,
+	src *storage.Object,
+	dstBase *url.URL,
+	key string,
+	newManifest bisyncManifest,
+	newManifestMu *sync.Mutex,
+) parallel.Task {
+	return func() error {
+		dsturl, err := url.New(strings.TrimSuffix(dstBase.Absolute(), "/") + "/" + key)
+		if err != nil {
+			return err
+		}
+
+		var transferErr error
+		switch {
+		case !src.URL.IsRemote() && dsturl.IsRemote():
+			transferErr = helper.doUpload(ctx, src.URL, dsturl)
+		case src.URL.IsRemote() && !dsturl.IsRemote():
+			transferErr = helper.doDownload(ctx, src.URL, dsturl)
+		default:
+			transferErr = helper.doCopy(ctx, src.URL, dsturl)
+		}
+		if transferErr != nil {
+			return &errorpkg.Error{Op: "bisync", Src: src.URL, Dst: dsturl, Err: transferErr}
+		}
+
+		newManifestMu.Lock()
+		newManifest[key] = entryFromObject(src)
+		newManifestMu.Unlock()
+		return nil
+	}
+}
+
+// deleteTask removes target (propagating a deletion that happened on the
+// other side) using the given client's Delete method.
+func (b Bisync) deleteTask(ctx context.Context, del func(context.Context, *url.URL) error, target *url.URL) parallel.Task {
+	return func() error {
+		if err := del(ctx, target); err != nil {
+			return &errorpkg.Error{Op: "bisync", Src: target, Err: err}
+		}
+		log.Info(log.InfoMessage{Operation: "delete", Source: target})
+		return nil
+	}
+}
+
+// resolveConflict handles a key that changed on both sides since the
+// last run. Without --conflict-resolve it leaves the key untouched on
+// both sides, records a .conflict marker, drops the key from the
+// manifest so it's reported again on the next run while the two sides
+// still differ, and returns an error
+// so the run as a whole is reported (and exits) as failed: an unresolved
+// conflict means bisync did not finish reconciling the two sides, and a
+// scripted/cron invocation needs a non-zero exit status to notice that.
+func (b Bisync) resolveConflict(
+	ctx context.Context,
+	helper Sync,
+	ch bisyncChange,
+	manifestFile string,
+	urlA, urlB *url.URL,
+	newManifest bisyncManifest,
+	newManifestMu *sync.Mutex,
+	waiter *parallel.Waiter,
+) error {
+	switch b.conflictResolve {
+	case "newer":
+		if ch.objA.ModTime != nil && ch.objB.ModTime != nil && ch.objA.ModTime.After(*ch.objB.ModTime) {
+			parallel.Run(b.propagateTask(ctx, helper, ch.objA, urlB, ch.key, newManifest, newManifestMu), waiter)
+		} else {
+			parallel.Run(b.propagateTask(ctx, helper, ch.objB, urlA, ch.key, newManifest, newManifestMu), waiter)
+		}
+	case "larger":
+		if ch.objA.Size >= ch.objB.Size {
+			parallel.Run(b.propagateTask(ctx, helper, ch.objA, urlB, ch.key, newManifest, newManifestMu), waiter)
+		} else {
+			parallel.Run(b.propagateTask(ctx, helper, ch.objB, urlA, ch.key, newManifest, newManifestMu), waiter)
+		}
+	case "path1":
+		parallel.Run(b.propagateTask(ctx, helper, ch.objA, urlB, ch.key, newManifest, newManifestMu), waiter)
+	case "path2":
+		parallel.Run(b.propagateTask(ctx, helper, ch.objB, urlA, ch.key, newManifest, newManifestMu), waiter)
+	default:
+		marker := conflictMarkerPath(manifestFile, ch.key)
+		if err := os.MkdirAll(filepath.Dir(marker), 0o755); err == nil {
+			data, _ := json.MarshalIndent(map[string]bisyncEntry{
+				b.pathA: entryFromObject(ch.objA),
+				b.pathB: entryFromObject(ch.objB),
+			}, "", "  ")
+			_ = os.WriteFile(marker, data, 0o644)
+		}
+		err := fmt.Errorf("%q changed on both sides; pass --conflict-resolve to reconcile (marker: %s)", ch.key, marker)
+		printError(b.fullCommand, b.op, err)
+		return err
+	}
+	return nil
+}
+
+func validateBisyncCommand(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return fmt.Errorf("expected two paths")
+	}
+
+	switch c.String("conflict-resolve") {
+	case "", "newer", "larger", "path1", "path2":
+	default:
+		return fmt.Errorf("unsupported --conflict-resolve value %q", c.String("conflict-resolve"))
+	}
+
+	algorithm, err := hash.ParseAlgorithm(c.String("checksum-algorithm"))
+	if err != nil {
+		return err
+	}
+	if hash.RequiresAdditionalChecksumWiring(algorithm) {
+		// See the identical check in validateSyncCommand: this build
+		// never populates storage.Object.AdditionalChecksums, so these
+		// algorithms would silently fall back to the weakest (size/mtime)
+		// comparison instead of the strongest.
+		return fmt.Errorf("checksum algorithm %q is not yet supported: it requires S3 additional checksums, which this build does not populate; use --checksum-algorithm=md5 or auto", algorithm)
+	}
+
+	pathA := c.Args().Get(0)
+	pathB := c.Args().Get(1)
+
+	urlA, err := url.New(pathA)
+	if err != nil {
+		return err
+	}
+
+	urlB, err := url.New(pathB)
+	if err != nil {
+		return err
+	}
+
+	if urlA.IsWildcard() || urlB.IsWildcard() {
+		return fmt.Errorf("bisync paths must not contain glob characters")
+	}
+
+	return nil
+}
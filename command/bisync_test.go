@@ -0,0 +1,194 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peak/s5cmd/storage"
+	"github.com/peak/s5cmd/storage/url"
+)
+
+func bisyncObject(t *testing.T, etag string, size int64, modTime time.Time) *storage.Object {
+	t.Helper()
+	return bisyncObjectAt(t, "key", etag, size, modTime)
+}
+
+func bisyncObjectAt(t *testing.T, key, etag string, size int64, modTime time.Time) *storage.Object {
+	t.Helper()
+
+	u, err := url.New("s3://bucket/" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &storage.Object{URL: u, Etag: etag, Size: size, ModTime: &modTime}
+}
+
+func closedChan(objs ...*storage.Object) <-chan *storage.Object {
+	ch := make(chan *storage.Object, len(objs))
+	for _, obj := range objs {
+		ch <- obj
+	}
+	close(ch)
+	return ch
+}
+
+func TestClassifyCommonUnchanged(t *testing.T) {
+	now := time.Now()
+	objA := bisyncObject(t, "etag", 10, now)
+	objB := bisyncObject(t, "etag", 10, now)
+	manifest := bisyncManifest{"key": entryFromObject(objA)}
+
+	b := Bisync{}
+	got := b.classifyCommon("key", objA, objB, manifest)
+	if got.kind != unchanged {
+		t.Errorf("expected unchanged, got %v", got.kind)
+	}
+}
+
+func TestClassifyCommonChangedOnOneSide(t *testing.T) {
+	now := time.Now()
+	prevObj := bisyncObject(t, "etag", 10, now)
+	manifest := bisyncManifest{"key": entryFromObject(prevObj)}
+
+	b := Bisync{}
+
+	changedA := bisyncObject(t, "new-etag", 20, now)
+	unchangedB := bisyncObject(t, "etag", 10, now)
+	got := b.classifyCommon("key", changedA, unchangedB, manifest)
+	if got.kind != changedOnA {
+		t.Errorf("expected changedOnA, got %v", got.kind)
+	}
+
+	unchangedA := bisyncObject(t, "etag", 10, now)
+	changedB := bisyncObject(t, "new-etag", 20, now)
+	got = b.classifyCommon("key", unchangedA, changedB, manifest)
+	if got.kind != changedOnB {
+		t.Errorf("expected changedOnB, got %v", got.kind)
+	}
+}
+
+func TestClassifyCommonConflicted(t *testing.T) {
+	now := time.Now()
+	prevObj := bisyncObject(t, "etag", 10, now)
+	manifest := bisyncManifest{"key": entryFromObject(prevObj)}
+
+	b := Bisync{}
+	changedA := bisyncObject(t, "a-etag", 20, now)
+	changedB := bisyncObject(t, "b-etag", 30, now)
+
+	got := b.classifyCommon("key", changedA, changedB, manifest)
+	if got.kind != conflicted {
+		t.Errorf("expected conflicted when both sides changed since the last run, got %v", got.kind)
+	}
+}
+
+// TestClassifyCommonResyncMismatchFavorsA covers the case the resync
+// comment in classifyCommon describes: if the two sides actually differ,
+// resync must not record "unchanged" (which would bake a manifest entry
+// that doesn't match B's real content); side A is authoritative, so the
+// key is classified as changed on A and A's copy is propagated over B.
+func TestClassifyCommonResyncMismatchFavorsA(t *testing.T) {
+	now := time.Now()
+	objA := bisyncObject(t, "a-etag", 10, now)
+	objB := bisyncObject(t, "b-etag", 20, now)
+
+	b := Bisync{resync: true}
+	got := b.classifyCommon("key", objA, objB, bisyncManifest{})
+	if got.kind != changedOnA {
+		t.Errorf("expected changedOnA for a resync mismatch, got %v", got.kind)
+	}
+}
+
+func TestClassifyCommonResyncMatching(t *testing.T) {
+	now := time.Now()
+	objA := bisyncObject(t, "etag", 10, now)
+	objB := bisyncObject(t, "etag", 10, now)
+
+	b := Bisync{resync: true}
+	got := b.classifyCommon("key", objA, objB, bisyncManifest{})
+	if got.kind != unchanged {
+		t.Errorf("expected unchanged for a resync where both sides already match, got %v", got.kind)
+	}
+}
+
+func TestClassifyOneSided(t *testing.T) {
+	now := time.Now()
+	obj := bisyncObject(t, "etag", 10, now)
+
+	b := Bisync{}
+
+	got := b.classifyOneSided("key", obj, nil, bisyncManifest{})
+	if got.kind != newOnA {
+		t.Errorf("expected newOnA for a key with no manifest entry, got %v", got.kind)
+	}
+
+	got = b.classifyOneSided("key", nil, obj, bisyncManifest{})
+	if got.kind != newOnB {
+		t.Errorf("expected newOnB for a key with no manifest entry, got %v", got.kind)
+	}
+
+	manifest := bisyncManifest{"key": entryFromObject(obj)}
+	got = b.classifyOneSided("key", obj, nil, manifest)
+	if got.kind != deletedOnB {
+		t.Errorf("expected deletedOnB for a key the manifest already knew about, got %v", got.kind)
+	}
+
+	got = b.classifyOneSided("key", nil, obj, manifest)
+	if got.kind != deletedOnA {
+		t.Errorf("expected deletedOnA for a key the manifest already knew about, got %v", got.kind)
+	}
+}
+
+func TestCountDeletions(t *testing.T) {
+	changes := []bisyncChange{
+		{kind: unchanged},
+		{kind: deletedOnA},
+		{kind: changedOnA},
+		{kind: deletedOnB},
+		{kind: newOnA},
+	}
+
+	if got := countDeletions(changes); got != 2 {
+		t.Errorf("expected 2 deletions, got %d", got)
+	}
+}
+
+// TestDiffLockstepMerge exercises diff's sorted merge-walk across keys
+// that only exist on one side interleaved with a key common to both, the
+// same shape march relies on in sync.go.
+func TestDiffLockstepMerge(t *testing.T) {
+	now := time.Now()
+
+	chA := closedChan(
+		bisyncObjectAt(t, "only-a", "etag", 5, now),
+		bisyncObjectAt(t, "shared", "etag", 10, now),
+	)
+	chB := closedChan(
+		bisyncObjectAt(t, "only-b", "etag", 5, now),
+		bisyncObjectAt(t, "shared", "etag", 10, now),
+	)
+
+	b := Bisync{}
+	changes, err := b.diff(chA, chB, bisyncManifest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	byKey := map[string]bisyncChange{}
+	for _, ch := range changes {
+		byKey[ch.key] = ch
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %d: %+v", len(changes), changes)
+	}
+	if got := byKey["only-a"].kind; got != newOnA {
+		t.Errorf("expected only-a to classify as newOnA, got %v", got)
+	}
+	if got := byKey["only-b"].kind; got != newOnB {
+		t.Errorf("expected only-b to classify as newOnB, got %v", got)
+	}
+	if got := byKey["shared"].kind; got != unchanged {
+		t.Errorf("expected shared to classify as unchanged, got %v", got)
+	}
+}
@@ -0,0 +1,208 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// transferCacheEntry records what a local file's content was last known
+// to hash to, and where it was last uploaded, so a later sync run over
+// an unchanged tree can skip hashing and uploading it entirely.
+type transferCacheEntry struct {
+	ETag   string `json:"etag"`
+	SHA256 string `json:"sha256,omitempty"`
+	// LastUploadedTo maps "bucket/key" to the version ID produced by the
+	// last upload to that destination.
+	LastUploadedTo map[string]string `json:"last_uploaded_to"`
+	UpdatedAt      int64             `json:"updated_at_unix_nano"`
+}
+
+// transferCache is a persistent, content-addressed index of local files
+// that have already been uploaded, keyed by (absolute path, size,
+// mtime). It's a single JSON file rather than BoltDB: s5cmd's index is
+// small (one entry per locally-synced file) and only ever has a single
+// writer, the s5cmd process itself -- but that single writer is still
+// many goroutines when sync/bisync run with concurrency > 1, so mu
+// guards entries/dirty against concurrent lookup/put/save calls. A
+// *transferCache is opened once per run and shared, never opened
+// per-file, so there's exactly one in-memory copy to keep consistent.
+type transferCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]transferCacheEntry
+	dirty   bool
+}
+
+func defaultCacheDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".s5cmd", "cache"), nil
+}
+
+func openTransferCache(dir string) (*transferCache, error) {
+	path := filepath.Join(dir, "index.json")
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &transferCache{path: path, entries: map[string]transferCacheEntry{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]transferCacheEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return &transferCache{path: path, entries: entries}, nil
+}
+
+func (c *transferCache) key(path string, size int64, modTime time.Time) string {
+	return fmt.Sprintf("%s:%d:%d", path, size, modTime.UnixNano())
+}
+
+// lookup returns the cached entry for (path, size, modTime), if any.
+func (c *transferCache) lookup(path string, size int64, modTime time.Time) (transferCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[c.key(path, size, modTime)]
+	return entry, ok
+}
+
+// put records that the local file at (path, size, modTime) was last
+// uploaded to dstBucketKey as etag/versionID.
+func (c *transferCache) put(path string, size int64, modTime time.Time, etag, dstBucketKey, versionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := c.key(path, size, modTime)
+	entry := c.entries[k]
+	entry.ETag = etag
+	if entry.LastUploadedTo == nil {
+		entry.LastUploadedTo = map[string]string{}
+	}
+	entry.LastUploadedTo[dstBucketKey] = versionID
+	entry.UpdatedAt = time.Now().UnixNano()
+	c.entries[k] = entry
+	c.dirty = true
+}
+
+// prune removes entries not updated within olderThan, reporting how many
+// were removed.
+func (c *transferCache) prune(olderThan time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := time.Now().Add(-olderThan).UnixNano()
+
+	removed := 0
+	for k, entry := range c.entries {
+		if entry.UpdatedAt < cutoff {
+			delete(c.entries, k)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	return removed
+}
+
+// save writes the cache to disk if it has unsaved changes. Callers should
+// open a transferCache once per run and call save once after all
+// concurrent lookup/put calls have finished, rather than per-file, so
+// there's a single read-modify-write cycle instead of one per file.
+func (c *transferCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0o644)
+}
+
+// NewCacheCommand returns the `s5cmd cache` command group, for managing
+// the local transfer cache out-of-band from a sync/cp run.
+func NewCacheCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "cache",
+		Usage:       "manage the local transfer cache",
+		Subcommands: []*cli.Command{newCachePruneCommand()},
+	}
+}
+
+func newCachePruneCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "remove transfer cache entries that haven't been touched recently",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "older-than",
+				Usage:    "remove entries not updated within this long, e.g. 720h or 30d",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "cache-dir",
+				Usage: "directory holding the transfer cache (default: ~/.s5cmd/cache)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			age, err := parseCacheAge(c.String("older-than"))
+			if err != nil {
+				return err
+			}
+
+			dir := c.String("cache-dir")
+			if dir == "" {
+				if dir, err = defaultCacheDir(); err != nil {
+					return err
+				}
+			}
+
+			cache, err := openTransferCache(dir)
+			if err != nil {
+				return err
+			}
+
+			removed := cache.prune(age)
+			if err := cache.save(); err != nil {
+				return err
+			}
+
+			fmt.Printf("pruned %d cache entries older than %s\n", removed, c.String("older-than"))
+			return nil
+		},
+	}
+}
+
+// parseCacheAge parses a duration the way time.ParseDuration does, plus
+// a "d" (day) unit: --older-than=30d reads far more naturally than
+// --older-than=720h for a flag whose whole purpose is expressing ages in
+// days.
+func parseCacheAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid --older-than value %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransferCacheLookupPut(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := openTransferCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Now()
+	if _, ok := cache.lookup("/local/file", 10, modTime); ok {
+		t.Fatalf("expected no entry before put")
+	}
+
+	cache.put("/local/file", 10, modTime, "etag", "bucket/key", "v1")
+
+	entry, ok := cache.lookup("/local/file", 10, modTime)
+	if !ok {
+		t.Fatalf("expected an entry after put")
+	}
+	if entry.ETag != "etag" {
+		t.Errorf("expected ETag %q, got %q", "etag", entry.ETag)
+	}
+	if entry.LastUploadedTo["bucket/key"] != "v1" {
+		t.Errorf("expected version %q for bucket/key, got %q", "v1", entry.LastUploadedTo["bucket/key"])
+	}
+
+	// A different size or mtime is a different cache key entirely, since
+	// either one changing means the file's content may have changed too.
+	if _, ok := cache.lookup("/local/file", 11, modTime); ok {
+		t.Errorf("expected no entry for a different size")
+	}
+	if _, ok := cache.lookup("/local/file", 10, modTime.Add(time.Second)); ok {
+		t.Errorf("expected no entry for a different mtime")
+	}
+}
+
+func TestTransferCachePut_SecondDestination(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := openTransferCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Now()
+	cache.put("/local/file", 10, modTime, "etag", "bucket/key1", "v1")
+	cache.put("/local/file", 10, modTime, "etag", "bucket/key2", "v2")
+
+	entry, ok := cache.lookup("/local/file", 10, modTime)
+	if !ok {
+		t.Fatalf("expected an entry after put")
+	}
+	if entry.LastUploadedTo["bucket/key1"] != "v1" || entry.LastUploadedTo["bucket/key2"] != "v2" {
+		t.Errorf("expected both destinations to be recorded, got %+v", entry.LastUploadedTo)
+	}
+}
+
+func TestTransferCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := openTransferCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	cache.put("/local/fresh", 1, now, "etag-fresh", "bucket/key", "v1")
+	cache.entries[cache.key("/local/stale", 1, now)] = transferCacheEntry{
+		ETag:      "etag-stale",
+		UpdatedAt: now.Add(-48 * time.Hour).UnixNano(),
+	}
+
+	removed := cache.prune(24 * time.Hour)
+	if removed != 1 {
+		t.Fatalf("expected 1 entry removed, got %d", removed)
+	}
+
+	if _, ok := cache.lookup("/local/fresh", 1, now); !ok {
+		t.Errorf("expected the fresh entry to survive pruning")
+	}
+	if _, ok := cache.lookup("/local/stale", 1, now); ok {
+		t.Errorf("expected the stale entry to be pruned")
+	}
+}
+
+func TestTransferCacheSaveAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := openTransferCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Now()
+	cache.put("/local/file", 10, modTime, "etag", "bucket/key", "v1")
+
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filepath.Abs(filepath.Join(dir, "index.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := openTransferCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok := reopened.lookup("/local/file", 10, modTime)
+	if !ok {
+		t.Fatalf("expected the saved entry to be loaded back")
+	}
+	if entry.ETag != "etag" {
+		t.Errorf("expected ETag %q, got %q", "etag", entry.ETag)
+	}
+}
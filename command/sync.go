@@ -15,6 +15,7 @@ import (
 	"github.com/peak/s5cmd/log/stat"
 	"github.com/peak/s5cmd/parallel"
 	"github.com/peak/s5cmd/storage"
+	"github.com/peak/s5cmd/storage/hash"
 	"github.com/peak/s5cmd/storage/url"
 )
 
@@ -51,6 +52,23 @@ func NewSyncCommandFlags() []cli.Flag {
 			Name:  "size-only",
 			Usage: "make size of object only criteria to decide whether an object should be synced",
 		},
+		&cli.BoolFlag{
+			Name:  "checksum",
+			Usage: "compare md5 checksum of objects instead of modification time and size to decide whether an object should be synced",
+		},
+		&cli.StringFlag{
+			Name:  "checksum-algorithm",
+			Value: string(hash.AlgorithmAuto),
+			Usage: "checksum algorithm to use with --checksum: auto or md5; sha256, sha1, crc32 and crc32c are rejected in this build since it does not populate S3 additional checksums",
+		},
+		&cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "directory for the local transfer cache (default: ~/.s5cmd/cache)",
+		},
+		&cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "disable the local transfer cache; always re-upload local files",
+		},
 	}
 }
 
@@ -88,22 +106,27 @@ type Sync struct {
 	fullCommand string
 
 	// flags
-	delete   bool
-	sizeOnly bool
+	delete            bool
+	sizeOnly          bool
+	checksum          bool
+	checksumAlgorithm hash.Algorithm
+	cacheDir          string
+	noCache           bool
 
 	// s3 options
 	concurrency int
 	partSize    int64
 	storageOpts storage.Options
 
-	// all objects
-	sourceObjects []*storage.Object
-	destObjects   []*storage.Object
-
-	// object channels
+	// object channels, filled by march as the two listings are merged.
 	onlySource chan *storage.Object
 	onlyDest   chan *url.URL
 	commonObj  chan *CommonObject
+
+	// cache is the local transfer cache, opened once for the whole run
+	// and shared across every concurrent doUpload call; it is nil if
+	// --no-cache was set or the cache couldn't be opened.
+	cache *transferCache
 }
 
 // NewSync creates Sync from cli.Context
@@ -115,8 +138,12 @@ func NewSync(c *cli.Context, deleteSource bool) Sync {
 		fullCommand: givenCommand(c),
 
 		// flags
-		delete:   c.Bool("delete"),
-		sizeOnly: c.Bool("size-only"),
+		delete:            c.Bool("delete"),
+		sizeOnly:          c.Bool("size-only"),
+		checksum:          c.Bool("checksum"),
+		checksumAlgorithm: hash.Algorithm(c.String("checksum-algorithm")),
+		cacheDir:          c.String("cache-dir"),
+		noCache:           c.Bool("no-cache"),
 
 		// s3 options
 		partSize:    c.Int64("part-size") * megabytes,
@@ -151,13 +178,6 @@ func (s Sync) Run(ctx context.Context) error {
 		return err
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		s.sourceObjects = sourceClient.ListSlice(ctx, srcurl, false)
-	}()
-
 	var destinationURLPath string
 	if strings.HasSuffix(s.dst, "/") {
 		destinationURLPath = s.dst + "*"
@@ -165,24 +185,25 @@ func (s Sync) Run(ctx context.Context) error {
 		destinationURLPath = s.dst + "/*"
 	}
 
-	fmt.Println("destination url path", destinationURLPath)
-
 	destObjectsURL, err := url.New(destinationURLPath)
 	if err != nil {
 		printError(s.fullCommand, s.op, err)
 		return err
 	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		s.destObjects = destClient.ListSlice(ctx, destObjectsURL, false)
-	}()
-
-	wg.Wait()
-
-	fmt.Printf("Source length %d\n", len(s.sourceObjects))
-	fmt.Printf("Dest length %d\n", len(s.destObjects))
+	// Opened once and shared for the whole run: doUpload is called from
+	// many concurrently-running tasks, and each one independently
+	// opening and saving its own copy would race and drop entries.
+	if !s.noCache {
+		cache, err := s.openCache()
+		if err != nil {
+			// A cache we can't open is not fatal to the sync; just skip
+			// the optimization and transfer as usual.
+			printError(s.fullCommand, s.op, err)
+		} else {
+			s.cache = cache
+		}
+	}
 
 	isBatch := srcurl.IsWildcard()
 	if !isBatch && !srcurl.IsRemote() {
@@ -190,46 +211,33 @@ func (s Sync) Run(ctx context.Context) error {
 		isBatch = obj != nil && obj.Type.IsDir()
 	}
 
-	s.commonObj = make(chan *CommonObject, len(s.sourceObjects))
-	s.onlySource = make(chan *storage.Object, len(s.sourceObjects))
-	s.onlyDest = make(chan *url.URL, len(s.destObjects))
-
-	var (
-		merrorChannelDest   error
-		merrorChannelSource error
-	)
-
-	// detect only destination and common objects.
+	// march's lockstep merge (below) requires both listings to arrive in
+	// one global lexicographic order, not just sorted within each
+	// directory. S3's ListObjectsV2 guarantees that globally. Whether the
+	// local lister does too isn't verifiable from this tree: it lives in
+	// storage/fs.go, which this snapshot doesn't contain, so whether it
+	// sorts per-directory (e.g. a plain filepath.Walk) or produces one
+	// global order across nested subdirs interleaved with sibling files
+	// is unknown here. march guards against the per-directory case at
+	// runtime -- see the monotonicity check in its doc comment -- so a
+	// violation surfaces as an error instead of a silent misclassification.
+	// Channels are small and bounded by concurrency, not by listing size:
+	// march and its consumers run concurrently, so a pair can be
+	// dispatched for transfer as soon as it is found, without waiting for
+	// either listing to finish.
+	sourceCh := sourceClient.List(ctx, srcurl, false)
+	destCh := destClient.List(ctx, destObjectsURL, false)
+
+	s.commonObj = make(chan *CommonObject, s.concurrency)
+	s.onlySource = make(chan *storage.Object, s.concurrency)
+	s.onlyDest = make(chan *url.URL, s.concurrency)
+
+	var merrorMarch error
 	go func() {
-		for _, destObject := range s.destObjects {
-			if s.shouldSkipObject(destObject, &merrorChannelDest, true) {
-				continue
-			}
-			foundIdx := s.doesSourceHave(s.sourceObjects, destObject, merrorChannelDest)
-			if foundIdx == -1 {
-				s.onlyDest <- destObject.URL
-			} else {
-				s.commonObj <- &CommonObject{src: s.sourceObjects[foundIdx], dst: destObject}
-			}
-		}
-		close(s.onlyDest)
-		close(s.commonObj)
-
-	}()
-
-	// detect only source objects.
-	go func() {
-		for _, srcObject := range s.sourceObjects {
-			if s.shouldSkipObject(srcObject, &merrorChannelSource, true) {
-				continue
-			}
-
-			foundIdx := s.doesSourceHave(s.destObjects, srcObject, merrorChannelSource)
-			if foundIdx == -1 {
-				s.onlySource <- srcObject
-			}
-		}
-		close(s.onlySource)
+		defer close(s.onlySource)
+		defer close(s.onlyDest)
+		defer close(s.commonObj)
+		s.march(sourceCh, destCh, &merrorMarch)
 	}()
 
 	waiter := parallel.NewWaiter()
@@ -253,59 +261,129 @@ func (s Sync) Run(ctx context.Context) error {
 		}
 	}()
 
-	// For the only source objects
-	for sourceObject := range s.onlySource {
-		var task parallel.Task
-		srcurl := sourceObject.URL
-		switch {
-		case !sourceObject.URL.IsRemote() && dsturl.IsRemote(): // local->remote
-			task = s.prepareUploadTask(ctx, srcurl, dsturl, isBatch)
-		case sourceObject.URL.IsRemote() && !dsturl.IsRemote(): // remote->local
-			task = s.prepareDownloadTask(ctx, srcurl, dsturl, isBatch)
-		case sourceObject.URL.IsRemote() && dsturl.IsRemote(): // remote->remote
-			task = s.prepareCopyTask(ctx, srcurl, dsturl, isBatch)
-		default:
-			panic("unexpected src-dst pair")
-		}
-		parallel.Run(task, waiter)
-	}
+	// onlySource, commonObj and onlyDest are all filled by the same march
+	// goroutine, so they must be drained concurrently: ranging over them
+	// one after another would let a full channel block march before it
+	// ever reaches the others.
+	var wg sync.WaitGroup
 
-	// for objects in both source and destination.
-	for commonObject := range s.commonObj {
-		var task parallel.Task
-		sourceObject, destObject := commonObject.src, commonObject.dst
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for sourceObject := range s.onlySource {
+			var task parallel.Task
+			srcurl := sourceObject.URL
+			switch {
+			case !sourceObject.URL.IsRemote() && dsturl.IsRemote(): // local->remote
+				task = s.prepareUploadTask(ctx, srcurl, dsturl, isBatch)
+			case sourceObject.URL.IsRemote() && !dsturl.IsRemote(): // remote->local
+				task = s.prepareDownloadTask(ctx, srcurl, dsturl, isBatch)
+			case sourceObject.URL.IsRemote() && dsturl.IsRemote(): // remote->remote
+				task = s.prepareCopyTask(ctx, srcurl, dsturl, isBatch)
+			default:
+				panic("unexpected src-dst pair")
+			}
+			parallel.Run(task, waiter)
+		}
+	}()
 
-		switch {
-		case !sourceObject.URL.IsRemote() && destObject.URL.IsRemote(): // local->remote
-			task = s.directUploadTask(ctx, sourceObject, destObject)
-		case sourceObject.URL.IsRemote() && !destObject.URL.IsRemote(): // remote->local
-			task = s.directDownloadTask(ctx, sourceObject, destObject)
-		case sourceObject.URL.IsRemote() && destObject.URL.IsRemote(): // remote->remote
-			task = s.directCopyTask(ctx, sourceObject, destObject)
-		default:
-			panic("unexpected src-dst pair")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for commonObject := range s.commonObj {
+			var task parallel.Task
+			sourceObject, destObject := commonObject.src, commonObject.dst
+
+			switch {
+			case !sourceObject.URL.IsRemote() && destObject.URL.IsRemote(): // local->remote
+				task = s.directUploadTask(ctx, sourceObject, destObject)
+			case sourceObject.URL.IsRemote() && !destObject.URL.IsRemote(): // remote->local
+				task = s.directDownloadTask(ctx, sourceObject, destObject)
+			case sourceObject.URL.IsRemote() && destObject.URL.IsRemote(): // remote->remote
+				task = s.directCopyTask(ctx, sourceObject, destObject)
+			default:
+				panic("unexpected src-dst pair")
+			}
+			parallel.Run(task, waiter)
 		}
-		parallel.Run(task, waiter)
-	}
+	}()
 
 	parallel.Run(s.prepareDeleteTask(ctx, dsturl), waiter)
 
+	wg.Wait()
 	waiter.Wait()
 	<-errDoneCh
 
-	return multierror.Append(merrorChannelDest, merrorWaiter, merrorChannelDest).ErrorOrNil()
+	if s.cache != nil {
+		if err := s.cache.save(); err != nil {
+			printError(s.fullCommand, s.op, err)
+			merrorWaiter = multierror.Append(merrorWaiter, err)
+		}
+	}
+
+	return multierror.Append(merrorMarch, merrorWaiter).ErrorOrNil()
 }
 
-func (s Sync) doesSourceHave(sourceObjects []*storage.Object, wantedObject *storage.Object, errorToWrite error) int {
-	for idx, source := range sourceObjects {
-		if s.shouldSkipObject(source, &errorToWrite, false) {
-			continue
+// march walks the source and destination listings in lockstep, the way
+// rclone's fs/march package reconciles two directory trees. At each step
+// the smaller of the two current keys is routed to onlySource or
+// onlyDest; equal keys are paired up on commonObj. This makes the diff
+// O(n+m) time and O(1) memory beyond the single object held from each
+// side, and never requires either listing to be fully drained first --
+// but it only produces a correct diff if both channels deliver their
+// objects in one global lexicographic order by ObjectPath. S3's listing
+// does; whether the local lister does is unverifiable in this snapshot
+// (see the comment at march's call site), so march checks it as it goes:
+// if either side ever yields a path that sorts before the previous one
+// it saw, that's proof the ordering assumption doesn't hold, and march
+// aborts into merror rather than risk silently routing objects to the
+// wrong channel (a spurious upload/download, a missed update, or -- under
+// --delete -- a wrongful deletion).
+func (s Sync) march(sourceCh, destCh <-chan *storage.Object, merror *error) {
+	srcObject, srcOk := <-sourceCh
+	destObject, destOk := <-destCh
+
+	var lastSrcPath, lastDestPath string
+
+	for srcOk || destOk {
+		if srcOk && lastSrcPath != "" && srcObject.URL.ObjectPath() < lastSrcPath {
+			*merror = multierror.Append(*merror, fmt.Errorf(
+				"source listing is not sorted: %q arrived after %q; sync diff aborted to avoid misclassifying objects",
+				srcObject.URL.ObjectPath(), lastSrcPath))
+			return
 		}
-		if source.URL.ObjectPath() == wantedObject.URL.ObjectPath() {
-			return idx
+		if destOk && lastDestPath != "" && destObject.URL.ObjectPath() < lastDestPath {
+			*merror = multierror.Append(*merror, fmt.Errorf(
+				"destination listing is not sorted: %q arrived after %q; sync diff aborted to avoid misclassifying objects",
+				destObject.URL.ObjectPath(), lastDestPath))
+			return
+		}
+
+		switch {
+		case destOk && (!srcOk || destObject.URL.ObjectPath() < srcObject.URL.ObjectPath()):
+			if !s.shouldSkipObject(destObject, merror, true) {
+				s.onlyDest <- destObject.URL
+			}
+			lastDestPath = destObject.URL.ObjectPath()
+			destObject, destOk = <-destCh
+		case srcOk && (!destOk || srcObject.URL.ObjectPath() < destObject.URL.ObjectPath()):
+			if !s.shouldSkipObject(srcObject, merror, true) {
+				s.onlySource <- srcObject
+			}
+			lastSrcPath = srcObject.URL.ObjectPath()
+			srcObject, srcOk = <-sourceCh
+		default: // equal keys on both sides
+			skipSrc := s.shouldSkipObject(srcObject, merror, true)
+			skipDest := s.shouldSkipObject(destObject, merror, true)
+			if !skipSrc && !skipDest {
+				s.commonObj <- &CommonObject{src: srcObject, dst: destObject}
+			}
+			lastSrcPath = srcObject.URL.ObjectPath()
+			lastDestPath = destObject.URL.ObjectPath()
+			srcObject, srcOk = <-sourceCh
+			destObject, destOk = <-destCh
 		}
 	}
-	return -1
 }
 
 func (s Sync) shouldSkipObject(object *storage.Object, errorToWrite *error, verbose bool) bool {
@@ -335,8 +413,14 @@ func (s Sync) prepareDeleteTask(
 ) func() error {
 	return func() error {
 
-		// if delete is not set, then return.
+		// march keeps writing dest-only keys to s.onlyDest regardless of
+		// whether --delete is set, so this task must always drain it even
+		// when there's nothing to delete with it; otherwise march blocks on
+		// a full channel as soon as there are more than s.concurrency
+		// dest-only keys, and the whole sync hangs.
 		if !s.delete {
+			for range s.onlyDest {
+			}
 			return nil
 		}
 		destClient, err := storage.NewClient(ctx, dsturl, s.storageOpts)
@@ -551,20 +635,49 @@ func (s Sync) doDownload(ctx context.Context, srcurl *url.URL, dsturl *url.URL)
 	return nil
 }
 
+// openCache opens the local transfer cache, honouring --cache-dir, or
+// the default ~/.s5cmd/cache if it isn't set.
+func (s Sync) openCache() (*transferCache, error) {
+	dir := s.cacheDir
+	if dir == "" {
+		var err error
+		dir, err = defaultCacheDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return openTransferCache(dir)
+}
+
 func (s Sync) doUpload(ctx context.Context, srcurl *url.URL, dsturl *url.URL) error {
 	srcClient := storage.NewLocalClient(s.storageOpts)
 
-	file, err := srcClient.Open(srcurl.Absolute())
+	obj, err := srcClient.Stat(ctx, srcurl)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
 	dstClient, err := storage.NewRemoteClient(ctx, dsturl, s.storageOpts)
 	if err != nil {
 		return err
 	}
 
+	// s.cache is opened once for the whole run and shared across every
+	// concurrently-running doUpload call; it's nil if --no-cache was set
+	// or the cache couldn't be opened. The lookup that can actually skip
+	// a transfer happens earlier, in shouldOverride, against dstObj's
+	// already-known ETag -- by the time doUpload runs, either there was
+	// no dest object to compare against (onlySource) or shouldOverride
+	// already decided a transfer is needed, so there's nothing left to
+	// check here; this call only ever records the outcome.
+	cache := s.cache
+
+	file, err := srcClient.Open(srcurl.Absolute())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
 	metadata := storage.NewMetadata()
 
 	err = dstClient.Put(ctx, file, dsturl, metadata, s.concurrency, s.partSize)
@@ -572,7 +685,6 @@ func (s Sync) doUpload(ctx context.Context, srcurl *url.URL, dsturl *url.URL) er
 		return err
 	}
 
-	obj, _ := srcClient.Stat(ctx, srcurl)
 	size := obj.Size
 
 	msg := log.InfoMessage{
@@ -585,6 +697,12 @@ func (s Sync) doUpload(ctx context.Context, srcurl *url.URL, dsturl *url.URL) er
 	}
 	log.Info(msg)
 
+	if cache != nil {
+		if dstObj, err := dstClient.Stat(ctx, dsturl); err == nil {
+			cache.put(srcurl.Absolute(), obj.Size, *obj.ModTime, dstObj.Etag, dsturl.Bucket+"/"+dsturl.ObjectPath(), dstObj.VersionID)
+		}
+	}
+
 	return nil
 }
 
@@ -617,6 +735,35 @@ func (s Sync) doCopy(ctx context.Context, srcurl, dsturl *url.URL) error {
 
 // shouldOverride function checks if the destination should be overridden if
 func (s Sync) shouldOverride(srcObj *storage.Object, dstObj *storage.Object) error {
+	// The cache is consulted here, before any transfer is dispatched, and
+	// against dstObj's already-known ETag -- not inside doUpload, which
+	// is only reached for commonObj pairs after this function has
+	// already decided a transfer is needed (too late to skip it), or for
+	// onlySource objects whose destination key doesn't exist yet by
+	// construction of march's classification (so a cache hit there can
+	// never happen at all). A file whose path, size and mtime are all
+	// unchanged since it was last uploaded, and whose destination still
+	// carries the ETag recorded then, skips hashing and re-uploading; a
+	// file whose mtime moved is a different cache key and misses.
+	if s.cache != nil && !srcObj.URL.IsRemote() && dstObj.URL.IsRemote() && srcObj.ModTime != nil {
+		if entry, ok := s.cache.lookup(srcObj.URL.Absolute(), srcObj.Size, *srcObj.ModTime); ok && entry.ETag != "" && entry.ETag == dstObj.Etag {
+			log.Info(log.InfoMessage{Operation: "cache-hit", Source: srcObj.URL, Destination: dstObj.URL, Object: &storage.Object{Size: srcObj.Size}})
+			return errorpkg.ErrObjectSizesMatch
+		}
+	}
+
+	if s.checksum {
+		err := hash.New(srcObj, s.checksumAlgorithm, s.partSize).Different(hash.New(dstObj, s.checksumAlgorithm, s.partSize))
+		// ErrorMultipartUpload means no checksum comparison was possible:
+		// the remote object exposes no usable ETag (e.g. it is SSE-C/SSE-KMS
+		// encrypted), or its multipart ETag can't be rebuilt with the
+		// configured --part-size. Fall through to the size/mtime comparison
+		// below in that case.
+		if err != errorpkg.ErrorMultipartUpload {
+			return err
+		}
+	}
+
 	// check size of objects
 	if srcObj.Size == dstObj.Size {
 		return errorpkg.ErrObjectSizesMatch
@@ -636,6 +783,21 @@ func validateSyncCommand(c *cli.Context) error {
 		return fmt.Errorf("expected source and destination arguments")
 	}
 
+	algorithm, err := hash.ParseAlgorithm(c.String("checksum-algorithm"))
+	if err != nil {
+		return err
+	}
+	if hash.RequiresAdditionalChecksumWiring(algorithm) {
+		// storage.Object.AdditionalChecksums is never populated by this
+		// client (that wiring lives in storage/s3.go's PutObject/List
+		// calls), so comparisons with this algorithm would have no
+		// additional checksum to use and would silently fall back to
+		// size/mtime -- the weakest comparison, not the strongest, and
+		// the opposite of what --checksum-algorithm=sha256 et al. ask
+		// for. Reject rather than let that happen quietly.
+		return fmt.Errorf("checksum algorithm %q is not yet supported: it requires S3 additional checksums, which this build does not populate; use --checksum-algorithm=md5 or auto", algorithm)
+	}
+
 	ctx := c.Context
 	src := c.Args().Get(0)
 	dst := c.Args().Get(1)
@@ -0,0 +1,118 @@
+package command
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/peak/s5cmd/storage"
+	"github.com/peak/s5cmd/storage/url"
+)
+
+func syncObjectAt(t *testing.T, rawURL, etag string, size int64) *storage.Object {
+	t.Helper()
+
+	u, err := url.New(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &storage.Object{URL: u, Etag: etag, Size: size}
+}
+
+func syncClosedChan(objs ...*storage.Object) <-chan *storage.Object {
+	ch := make(chan *storage.Object, len(objs))
+	for _, obj := range objs {
+		ch <- obj
+	}
+	close(ch)
+	return ch
+}
+
+// drainMarch runs march to completion against freshly made channels on s,
+// collecting everything it routed to onlySource/onlyDest/commonObj.
+func drainMarch(t *testing.T, s *Sync, sourceCh, destCh <-chan *storage.Object) (onlySource []*storage.Object, onlyDest []*url.URL, common []*CommonObject, merror error) {
+	t.Helper()
+
+	s.onlySource = make(chan *storage.Object, 16)
+	s.onlyDest = make(chan *url.URL, 16)
+	s.commonObj = make(chan *CommonObject, 16)
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for obj := range s.onlySource {
+			onlySource = append(onlySource, obj)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for u := range s.onlyDest {
+			onlyDest = append(onlyDest, u)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for c := range s.commonObj {
+			common = append(common, c)
+		}
+	}()
+
+	s.march(sourceCh, destCh, &merror)
+	close(s.onlySource)
+	close(s.onlyDest)
+	close(s.commonObj)
+	wg.Wait()
+
+	return onlySource, onlyDest, common, merror
+}
+
+func TestMarchClassifiesSortedListings(t *testing.T) {
+	s := &Sync{}
+
+	sourceCh := syncClosedChan(
+		syncObjectAt(t, "s3://bucket/only-src", "etag", 1),
+		syncObjectAt(t, "s3://bucket/shared", "etag", 2),
+	)
+	destCh := syncClosedChan(
+		syncObjectAt(t, "s3://bucket/only-dst", "etag", 1),
+		syncObjectAt(t, "s3://bucket/shared", "etag", 2),
+	)
+
+	onlySource, onlyDest, common, merror := drainMarch(t, s, sourceCh, destCh)
+	if merror != nil {
+		t.Fatalf("unexpected error: %v", merror)
+	}
+
+	if len(onlySource) != 1 || onlySource[0].URL.ObjectPath() != "only-src" {
+		t.Errorf("expected only-src to be routed to onlySource, got %+v", onlySource)
+	}
+	if len(onlyDest) != 1 || onlyDest[0].ObjectPath() != "only-dst" {
+		t.Errorf("expected only-dst to be routed to onlyDest, got %+v", onlyDest)
+	}
+	if len(common) != 1 || common[0].src.URL.ObjectPath() != "shared" {
+		t.Errorf("expected shared to be paired as a common object, got %+v", common)
+	}
+}
+
+// TestMarchDetectsUnsortedListing covers the case the chunk0-1 fix added:
+// march can only classify correctly if both listings arrive in one
+// global lexicographic order, which can't be verified for the local
+// walker in this tree (see the comment at its call site). If that order
+// is ever violated, march must abort into merror instead of silently
+// misclassifying objects.
+func TestMarchDetectsUnsortedListing(t *testing.T) {
+	s := &Sync{}
+
+	// "b" then "a" is out of order: a correctly sorted listing would
+	// never yield "a" after "b".
+	sourceCh := syncClosedChan(
+		syncObjectAt(t, "s3://bucket/b", "etag", 1),
+		syncObjectAt(t, "s3://bucket/a", "etag", 1),
+	)
+	destCh := syncClosedChan()
+
+	_, _, _, merror := drainMarch(t, s, sourceCh, destCh)
+	if merror == nil {
+		t.Fatalf("expected march to report an error for an out-of-order source listing")
+	}
+}
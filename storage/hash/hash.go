@@ -2,7 +2,13 @@ package hash
 
 import (
 	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	gohash "hash"
+	"hash/crc32"
 	"io"
 	"os"
 	"strconv"
@@ -12,72 +18,311 @@ import (
 	"github.com/peak/s5cmd/storage"
 )
 
+// Algorithm identifies a checksum algorithm s5cmd can use to compare
+// objects, beyond S3's default ETag.
+type Algorithm string
+
+// Supported checksum algorithms. AlgorithmAuto negotiates the strongest
+// algorithm both sides of a comparison expose, falling back to MD5/ETag
+// when neither does.
+const (
+	AlgorithmAuto   Algorithm = "auto"
+	AlgorithmMD5    Algorithm = "md5"
+	AlgorithmSHA256 Algorithm = "sha256"
+	AlgorithmSHA1   Algorithm = "sha1"
+	AlgorithmCRC32  Algorithm = "crc32"
+	AlgorithmCRC32C Algorithm = "crc32c"
+)
+
+// checksumPriority ranks the additional-checksum algorithms from
+// strongest to weakest; it's the order AlgorithmAuto negotiates in.
+var checksumPriority = []Algorithm{AlgorithmSHA256, AlgorithmSHA1, AlgorithmCRC32C, AlgorithmCRC32}
+
+// ParseAlgorithm validates and normalizes a --checksum-algorithm value.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch algorithm := Algorithm(s); algorithm {
+	case AlgorithmAuto, AlgorithmMD5, AlgorithmSHA256, AlgorithmSHA1, AlgorithmCRC32, AlgorithmCRC32C:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %q", s)
+	}
+}
+
+// RequiresAdditionalChecksumWiring reports whether algorithm can only ever
+// compare objects via an S3 additional checksum (never ETag), which in
+// turn requires storage.Object.AdditionalChecksums to actually be
+// populated by the storage client's PutObject/List/HeadObject calls.
+// AlgorithmAuto and AlgorithmMD5 are excluded: both have an ETag fallback
+// and so degrade gracefully, unlike an explicit sha256/sha1/crc32/crc32c
+// request, which has nowhere to fall back to but the comparison this
+// package's caller is trying to avoid.
+func RequiresAdditionalChecksumWiring(algorithm Algorithm) bool {
+	switch algorithm {
+	case AlgorithmSHA256, AlgorithmSHA1, AlgorithmCRC32, AlgorithmCRC32C:
+		return true
+	default:
+		return false
+	}
+}
+
 // ObjectHash defines hash related properties of storage.Object structure
 type ObjectHash struct {
-	object    *storage.Object
-	multipart int
-	isLocal   bool
+	object      *storage.Object
+	algorithm   Algorithm
+	isMultipart bool
+	numParts    int
+	isLocal     bool
+	partSize    int64
 }
 
-// New returns a new ObjectHash object.
-func New(object *storage.Object) *ObjectHash {
-	// fmt.Printf("object %#v\n", object.URL)
+// New returns a new ObjectHash object. algorithm selects how Different
+// compares object against its target; AlgorithmAuto negotiates the best
+// available algorithm at comparison time. partSize is the part size this
+// run would use to upload object; it's the only part size worth trying
+// when reconstructing a multipart ETag, since S3 never returns the part
+// size an existing object was actually uploaded with.
+func New(object *storage.Object, algorithm Algorithm, partSize int64) *ObjectHash {
+	isMultipart, numParts := checkMultipart(object.Etag)
 	return &ObjectHash{
-		object:    object,
-		multipart: checkMultipart(object.Etag),
-		isLocal:   !object.URL.IsRemote(),
+		object:      object,
+		algorithm:   algorithm,
+		isMultipart: isMultipart,
+		numParts:    numParts,
+		isLocal:     !object.URL.IsRemote(),
+		partSize:    partSize,
 	}
 }
 
 // Different checks is given objecthash is different than source hash.
+//
+// When an S3 additional checksum (SHA256/SHA1/CRC32/CRC32C) is available
+// on both sides, or was explicitly requested via algorithm, it is
+// preferred over the ETag: additional checksums, unlike ETags, are
+// stable across multipart boundaries and copy operations. Otherwise
+// Different falls back to ETag comparison.
+//
+// A local file has no ETag of its own, so it is hashed on demand using
+// the remote side's upload layout: a single MD5 of its contents if the
+// remote object is a single-part upload, or S3's multipart ETag recipe
+// (MD5 of the concatenated per-part MD5s) if it was uploaded in parts.
+// If the remote object doesn't expose a usable checksum at all (e.g. it
+// was encrypted with SSE-C or SSE-KMS), or its multipart ETag can't be
+// reproduced with the configured part size (see partSizeForReconstruction;
+// the part size is not inferred from the object's size and part count),
+// ErrorMultipartUpload is returned so callers can fall back to comparing
+// size and mtime instead.
 func (o *ObjectHash) Different(target *ObjectHash) error {
-	if o.multipart != 0 { // source is multipart uploaded.
-		return errorpkg.ErrorMultipartUpload
+	algorithm := o.algorithm
+	if algorithm == "" || algorithm == AlgorithmAuto {
+		algorithm = negotiate(o, target)
 	}
 
-	if target.multipart != 0 { // target is multipart uploaded.
-		return errorpkg.ErrorMultipartUpload
+	if algorithm != AlgorithmAuto && algorithm != AlgorithmMD5 {
+		return o.differentByAdditionalChecksum(target, algorithm)
 	}
 
 	if o.isLocal { // local -> remote
-		localHash, err := fileHash(o.object.URL.Path)
+		localHash, err := o.localHash(target)
 		if err != nil {
 			return err
 		}
 		if localHash == target.object.Etag {
 			return errorpkg.ErrorSameHash
-		} else {
-			return nil
 		}
-	} else {
-		if target.isLocal { // remote -> local
-			localHash, err := fileHash(target.object.URL.Path)
-			if err != nil {
-				return err
-			}
-			if o.object.Etag == localHash {
-				return errorpkg.ErrorSameHash
-			} else {
-				return nil
-			}
-		} else { // remote -> remote
-			if o.object.Etag == target.object.Etag {
-				return errorpkg.ErrorSameHash
-			} else {
-				return nil
-			}
+		return nil
+	}
+
+	if target.isLocal { // remote -> local
+		localHash, err := target.localHash(o)
+		if err != nil {
+			return err
+		}
+		if o.object.Etag == localHash {
+			return errorpkg.ErrorSameHash
+		}
+		return nil
+	}
+
+	// remote -> remote
+	if o.object.Etag == "" || target.object.Etag == "" {
+		return errorpkg.ErrorMultipartUpload
+	}
+	if o.object.Etag == target.object.Etag {
+		return errorpkg.ErrorSameHash
+	}
+	return nil
+}
+
+// localHash computes the ETag o's local file would have if it had been
+// uploaded with the same part layout as remote.
+func (o *ObjectHash) localHash(remote *ObjectHash) (string, error) {
+	if remote.object.Etag == "" {
+		return "", errorpkg.ErrorMultipartUpload
+	}
+
+	if !remote.isMultipart {
+		return fileHash(o.object.URL.Path)
+	}
+
+	partSize, err := remote.partSizeForReconstruction()
+	if err != nil {
+		return "", err
+	}
+
+	return multipartFileHash(o.object.URL.Path, partSize, remote.numParts)
+}
+
+// partSizeForReconstruction returns the part size to use to recompute the
+// remote object's multipart ETag, or ErrorMultipartUpload if the layout
+// can't be reliably reconstructed.
+//
+// A part size only reproduces the real ETag if splitting the object into
+// numParts-1 parts of that size plus one (possibly shorter) tail part
+// adds up to exactly the object's size. S3 doesn't return the part size
+// an object was actually uploaded with, so the only candidate worth
+// trying is o.partSize, the part size this run is itself configured to
+// upload with: if the object was produced by a previous run with the
+// same --part-size, that's the value that reconstructs it. Guessing an
+// even split of size/numParts instead (as if part size could be inferred
+// from size and count alone) only happens to match when size divides
+// numParts evenly, and silently produces a wrong ETag otherwise, so it's
+// not done here.
+func (o *ObjectHash) partSizeForReconstruction() (int64, error) {
+	if o.numParts == 0 {
+		return 0, errorpkg.ErrorMultipartUpload
+	}
+	if o.numParts == 1 {
+		return o.object.Size, nil
+	}
+	fullParts := int64(o.numParts - 1)
+	if o.partSize > 0 && fullParts*o.partSize < o.object.Size && o.object.Size <= int64(o.numParts)*o.partSize {
+		return o.partSize, nil
+	}
+	return 0, errorpkg.ErrorMultipartUpload
+}
+
+// negotiate picks the strongest additional-checksum algorithm exposed by
+// both o and target, the way rclone's Hashes().Overlap() does. A local
+// side can compute any algorithm on demand, so it's treated as
+// supporting all of them; a remote side only supports the additional
+// checksums S3 recorded for it. It returns AlgorithmAuto if no algorithm
+// is shared, in which case callers fall back to ETag comparison.
+func negotiate(o, target *ObjectHash) Algorithm {
+	srcAvail := availableAlgorithms(o)
+	dstAvail := availableAlgorithms(target)
+	for _, algorithm := range checksumPriority {
+		if srcAvail[algorithm] && dstAvail[algorithm] {
+			return algorithm
+		}
+	}
+	return AlgorithmAuto
+}
+
+// availableAlgorithms returns the additional-checksum algorithms o can be
+// compared by: every algorithm for a local file, since it can be hashed
+// on demand, or whatever checksums are recorded for a remote object in
+// object.AdditionalChecksums. This package only reads that map; it is
+// non-empty only where the storage client filled it in from the
+// x-amz-checksum-* values S3 returns, and an object with nothing recorded
+// there reports no available algorithms, so negotiate falls back to
+// ETag/MD5.
+func availableAlgorithms(o *ObjectHash) map[Algorithm]bool {
+	if o.isLocal {
+		avail := make(map[Algorithm]bool, len(checksumPriority))
+		for _, algorithm := range checksumPriority {
+			avail[algorithm] = true
+		}
+		return avail
+	}
+
+	avail := make(map[Algorithm]bool, len(o.object.AdditionalChecksums))
+	for algorithm, value := range o.object.AdditionalChecksums {
+		if value != "" {
+			avail[Algorithm(algorithm)] = true
 		}
 	}
+	return avail
+}
+
+// differentByAdditionalChecksum compares o and target using an S3
+// additional checksum instead of ETag.
+func (o *ObjectHash) differentByAdditionalChecksum(target *ObjectHash, algorithm Algorithm) error {
+	srcSum, err := o.additionalChecksum(algorithm)
+	if err != nil {
+		return err
+	}
+
+	dstSum, err := target.additionalChecksum(algorithm)
+	if err != nil {
+		return err
+	}
+
+	if srcSum == dstSum {
+		return errorpkg.ErrorSameHash
+	}
+	return nil
+}
+
+// additionalChecksum returns o's base64-encoded checksum for algorithm,
+// computing it locally if o is a local file, or reading it from the
+// object's recorded S3 additional checksum otherwise. It returns
+// ErrorMultipartUpload if a remote object has no such checksum recorded,
+// so callers can fall back to ETag/size/mtime comparison.
+func (o *ObjectHash) additionalChecksum(algorithm Algorithm) (string, error) {
+	if o.isLocal {
+		return localAdditionalChecksum(o.object.URL.Path, algorithm)
+	}
+
+	sum, ok := o.object.AdditionalChecksums[string(algorithm)]
+	if !ok || sum == "" {
+		return "", errorpkg.ErrorMultipartUpload
+	}
+	return sum, nil
+}
+
+// localAdditionalChecksum computes the base64-encoded checksum of the
+// local file at path using algorithm.
+func localAdditionalChecksum(path string, algorithm Algorithm) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var h gohash.Hash
+	switch algorithm {
+	case AlgorithmSHA256:
+		h = sha256.New()
+	case AlgorithmSHA1:
+		h = sha1.New()
+	case AlgorithmCRC32:
+		h = crc32.NewIEEE()
+	case AlgorithmCRC32C:
+		h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	default:
+		return "", fmt.Errorf("hash: unsupported checksum algorithm %q", algorithm)
+	}
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
 }
 
-// checkMultipart checks if given object is uploaded using multipart.
-func checkMultipart(hashValue string) int {
-	splits := strings.Split(hashValue, "-")
-	if len(splits) != 2 {
-		return 0
+// checkMultipart parses an S3 multipart ETag of the form "<md5>-<N>" and
+// reports whether hashValue is one, along with its part count N.
+func checkMultipart(hashValue string) (bool, int) {
+	hashValue = strings.Trim(hashValue, `"`)
+	idx := strings.LastIndex(hashValue, "-")
+	if idx == -1 {
+		return false, 0
 	}
-	multipart, _ := strconv.Atoi(splits[1])
-	return multipart
+
+	numParts, err := strconv.Atoi(hashValue[idx+1:])
+	if err != nil || numParts <= 0 {
+		return false, 0
+	}
+	return true, numParts
 }
 
 // fileHash computes hash of local file.
@@ -104,4 +349,30 @@ func fileToHash(r io.Reader) (string, error) {
 	MD5String = hex.EncodeToString(hashInBytes)
 
 	return MD5String, nil
-}
\ No newline at end of file
+}
+
+// multipartFileHash computes the ETag a local file would be given if
+// uploaded to S3 in numParts parts of partSize bytes each (the last part
+// may be shorter): MD5 each part, concatenate the raw digests, MD5 the
+// concatenation, and format the result as "<md5>-<numParts>".
+func multipartFileHash(path string, partSize int64, numParts int) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var digests []byte
+	buf := make([]byte, partSize)
+	for i := 0; i < numParts; i++ {
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		partDigest := md5.Sum(buf[:n])
+		digests = append(digests, partDigest[:]...)
+	}
+
+	sum := md5.Sum(digests)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), numParts), nil
+}
@@ -0,0 +1,199 @@
+package hash
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	errorpkg "github.com/peak/s5cmd/error"
+	"github.com/peak/s5cmd/storage"
+	"github.com/peak/s5cmd/storage/url"
+)
+
+// localMultipartEtag computes the reference multipart ETag for data,
+// uploaded in parts of partSize bytes (last part possibly shorter), using
+// the same recipe S3 does: MD5 each part, concatenate the raw digests,
+// then MD5 the concatenation.
+func localMultipartEtag(t *testing.T, data []byte, partSize int) string {
+	t.Helper()
+
+	var digests []byte
+	numParts := 0
+	for offset := 0; offset < len(data); offset += partSize {
+		end := offset + partSize
+		if end > len(data) {
+			end = len(data)
+		}
+		partDigest := md5.Sum(data[offset:end])
+		digests = append(digests, partDigest[:]...)
+		numParts++
+	}
+
+	sum := md5.Sum(digests)
+	return fmt.Sprintf("%x-%d", sum, numParts)
+}
+
+func tempFile(t *testing.T, data []byte) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "object")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func localObject(t *testing.T, data []byte) *storage.Object {
+	t.Helper()
+
+	path := tempFile(t, data)
+	u, err := url.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &storage.Object{URL: u, Size: int64(len(data))}
+}
+
+func remoteObject(t *testing.T, etag string, size int64) *storage.Object {
+	t.Helper()
+
+	u, err := url.New("s3://bucket/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &storage.Object{URL: u, Etag: etag, Size: size}
+}
+
+func TestObjectHashDifferentSinglePart(t *testing.T) {
+	data := []byte("hello s5cmd")
+	local := localObject(t, data)
+
+	sum := md5.Sum(data)
+	etag := fmt.Sprintf("%x", sum)
+
+	same := New(local, AlgorithmAuto, 0).Different(New(remoteObject(t, etag, int64(len(data))), AlgorithmAuto, 0))
+	if same != errorpkg.ErrorSameHash {
+		t.Errorf("expected ErrorSameHash for matching single-part ETag, got %v", same)
+	}
+
+	different := New(local, AlgorithmAuto, 0).Different(New(remoteObject(t, "deadbeefdeadbeefdeadbeefdeadbeef", int64(len(data))), AlgorithmAuto, 0))
+	if different != nil {
+		t.Errorf("expected nil (different) for mismatching single-part ETag, got %v", different)
+	}
+}
+
+func TestObjectHashDifferentMultipartEqualParts(t *testing.T) {
+	const partSize = 16
+	data := make([]byte, partSize*3)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	local := localObject(t, data)
+
+	etag := localMultipartEtag(t, data, partSize)
+
+	same := New(local, AlgorithmAuto, partSize).Different(New(remoteObject(t, etag, int64(len(data))), AlgorithmAuto, partSize))
+	if same != errorpkg.ErrorSameHash {
+		t.Errorf("expected ErrorSameHash for matching multipart ETag, got %v", same)
+	}
+}
+
+func TestObjectHashDifferentMultipartShortTail(t *testing.T) {
+	const partSize = 16
+	data := make([]byte, partSize*2+5) // two full parts, one short tail part
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	local := localObject(t, data)
+
+	etag := localMultipartEtag(t, data, partSize)
+
+	same := New(local, AlgorithmAuto, partSize).Different(New(remoteObject(t, etag, int64(len(data))), AlgorithmAuto, partSize))
+	if same != errorpkg.ErrorSameHash {
+		t.Errorf("expected ErrorSameHash for matching multipart ETag with short tail, got %v", same)
+	}
+
+	different := New(local, AlgorithmAuto, partSize).Different(New(remoteObject(t, "deadbeefdeadbeefdeadbeefdeadbeef-3", int64(len(data))), AlgorithmAuto, partSize))
+	if different != nil {
+		t.Errorf("expected a non-matching ETag to be reported as different (nil), got %v", different)
+	}
+}
+
+// TestObjectHashDifferentMultipartUnknownPartSize covers the case this
+// package previously got wrong: with no configured part size to validate
+// against, and a size that isn't evenly divisible by numParts, the part
+// layout can't be reliably reconstructed, so Different must report
+// ErrorMultipartUpload (letting callers fall back to size/mtime) instead
+// of guessing an even split and risking a false "different".
+func TestObjectHashDifferentMultipartUnknownPartSize(t *testing.T) {
+	const partSize = 16
+	data := make([]byte, partSize*2+5) // two full parts, one short tail part
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	local := localObject(t, data)
+
+	etag := localMultipartEtag(t, data, partSize)
+
+	result := New(local, AlgorithmAuto, 0).Different(New(remoteObject(t, etag, int64(len(data))), AlgorithmAuto, 0))
+	if result != errorpkg.ErrorMultipartUpload {
+		t.Errorf("expected ErrorMultipartUpload when part size is unknown and size doesn't divide evenly, got %v", result)
+	}
+}
+
+func TestObjectHashDifferentAdditionalChecksum(t *testing.T) {
+	u, err := url.New("s3://bucket/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := &storage.Object{
+		URL:                 u,
+		Etag:                "deadbeefdeadbeefdeadbeefdeadbeef-2",
+		AdditionalChecksums: map[string]string{"sha256": "c2FtZQ=="},
+	}
+	dstSame := &storage.Object{
+		URL:                 u,
+		Etag:                "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-3",
+		AdditionalChecksums: map[string]string{"sha256": "c2FtZQ=="},
+	}
+	dstDifferent := &storage.Object{
+		URL:                 u,
+		Etag:                "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-3",
+		AdditionalChecksums: map[string]string{"sha256": "ZGlmZmVyZW50"},
+	}
+
+	// Even though ETags differ (as multipart ETags do across copies), a
+	// matching additional checksum should win when negotiated via auto.
+	same := New(src, AlgorithmAuto, 0).Different(New(dstSame, AlgorithmAuto, 0))
+	if same != errorpkg.ErrorSameHash {
+		t.Errorf("expected ErrorSameHash when additional checksums match, got %v", same)
+	}
+
+	different := New(src, AlgorithmAuto, 0).Different(New(dstDifferent, AlgorithmAuto, 0))
+	if different != nil {
+		t.Errorf("expected nil (different) when additional checksums differ, got %v", different)
+	}
+}
+
+func TestCheckMultipart(t *testing.T) {
+	cases := []struct {
+		etag        string
+		isMultipart bool
+		numParts    int
+	}{
+		{`"9bb58f26192e4ba00f01e2e7b136bbd8"`, false, 0},
+		{"9bb58f26192e4ba00f01e2e7b136bbd8-3", true, 3},
+		{"", false, 0},
+	}
+
+	for _, c := range cases {
+		isMultipart, numParts := checkMultipart(c.etag)
+		if isMultipart != c.isMultipart || numParts != c.numParts {
+			t.Errorf("checkMultipart(%q) = (%v, %v), want (%v, %v)", c.etag, isMultipart, numParts, c.isMultipart, c.numParts)
+		}
+	}
+}